@@ -0,0 +1,302 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	clientset "k8s.io/client-go/kubernetes"
+
+	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
+	kubeadmapiv1 "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm/v1beta4"
+	"k8s.io/kubernetes/cmd/kubeadm/app/cmd/options"
+	cmdutil "k8s.io/kubernetes/cmd/kubeadm/app/cmd/util"
+	configutil "k8s.io/kubernetes/cmd/kubeadm/app/util/config"
+	kubeconfigutil "k8s.io/kubernetes/cmd/kubeadm/app/util/kubeconfig"
+	"k8s.io/kubernetes/cmd/kubeadm/app/util/preflight"
+)
+
+// applyPlanFlags holds the values for the common flags shared between `kubeadm upgrade apply` and
+// `kubeadm upgrade plan`.
+type applyPlanFlags struct {
+	kubeConfigPath            string
+	cfgPath                   string
+	featureGatesString        string
+	allowExperimentalUpgrades bool
+	allowRCUpgrades           bool
+	printConfig               bool
+	out                       io.Writer
+}
+
+// applyFlags holds the information about the flags that can be passed to `kubeadm upgrade apply`.
+type applyFlags struct {
+	*applyPlanFlags
+
+	nonInteractiveMode      bool
+	force                   bool
+	dryRun                  bool
+	etcdUpgrade             bool
+	renewCerts              bool
+	imagePullTimeout        time.Duration
+	ignorePreflightErrors   []string
+	patchesDir              string
+	verifyImageAttestations bool
+	output                  string
+	rollback                bool
+	rollbackFrom            string
+
+	// getClient constructs the Kubernetes client that the apply phases operate against. It defaults
+	// to getClient (a thin wrapper around kubeconfigutil.ClientSetFromFile) but tests can override it
+	// with a constructor that returns a fake.Clientset so newApplyData can be exercised end-to-end
+	// without a real kubeconfig on disk.
+	getClient func(file string, timeout time.Duration) (clientset.Interface, error)
+}
+
+// applyData defines all the runtime information used when running the kubeadm upgrade apply workflow;
+// this data is shared across all the phases that are included in the workflow.
+type applyData struct {
+	nonInteractiveMode    bool
+	dryRun                bool
+	force                 bool
+	upgradeVersion        string
+	imagePullPolicy       string
+	imagePullSerial       bool
+	etcdUpgrade           bool
+	renewCerts            bool
+	patchesDir            string
+	ignorePreflightErrors sets.Set[string]
+	rollback              bool
+	rollbackDir           string
+
+	client clientset.Interface
+	cfg    *kubeadmapi.UpgradeConfiguration
+
+	// attestationManifest holds the expected per-component image digests loaded from
+	// cfg.Apply.Attestation. It is nil unless --verify-image-attestations is set, an attestation block
+	// is configured and ImageAttestation is not in --ignore-preflight-errors; it is always nil with
+	// --rollback. It is consulted by
+	// verifyComponentImageAttestations before any static pod manifest is written during apply.
+	attestationManifest *imageAttestationManifest
+
+	// emitter is used by the apply phases to report progress; it is selected in newApplyData from
+	// the --output flag or UpgradeConfiguration.Apply.Output.
+	emitter EventEmitter
+}
+
+// newCmdApply returns the cobra command for `kubeadm upgrade apply`.
+func newCmdApply(apf *applyPlanFlags) *cobra.Command {
+	flags := &applyFlags{
+		applyPlanFlags:   apf,
+		imagePullTimeout: 15 * time.Minute,
+		getClient:        getClient,
+		output:           outputText,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "apply [version]",
+		Short: "Upgrade your Kubernetes cluster to the specified version",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := newApplyData(cmd, args, flags)
+			if err != nil {
+				return err
+			}
+			return runApply(data)
+		},
+	}
+
+	addApplyPlanFlags(cmd.Flags(), flags.applyPlanFlags)
+	options.AddPatchesFlag(cmd.Flags(), &flags.patchesDir)
+	options.AddIgnorePreflightErrorsFlag(cmd.Flags(), &flags.ignorePreflightErrors)
+	cmd.Flags().BoolVarP(&flags.nonInteractiveMode, options.NonInteractiveMode, "y", flags.nonInteractiveMode, "Perform the upgrade and do not prompt for confirmation (non-interactive mode).")
+	cmd.Flags().BoolVarP(&flags.force, options.Force, "f", flags.force, "Force upgrading although some requirements might not be met. This also implies non-interactive mode.")
+	cmd.Flags().BoolVar(&flags.dryRun, options.DryRun, flags.dryRun, "Do not change any state, just output the actions that would be performed.")
+	cmd.Flags().BoolVar(&flags.etcdUpgrade, options.EtcdUpgrade, flags.etcdUpgrade, "Perform the upgrade of etcd.")
+	cmd.Flags().BoolVar(&flags.renewCerts, options.CertificateRenewal, flags.renewCerts, "Perform the renewal of certificates used by components changed during the upgrade.")
+	cmd.Flags().DurationVar(&flags.imagePullTimeout, options.ImagePullTimeout, flags.imagePullTimeout, "The value is not used: the control plane image pulls run without a time limit.")
+	cmd.Flags().BoolVar(&flags.verifyImageAttestations, "verify-image-attestations", flags.verifyImageAttestations, "Verify the control plane, kube-proxy and CoreDNS images against the signed manifest in UpgradeConfiguration.apply.attestation before pinning them into the upgraded manifests.")
+	cmd.Flags().StringVarP(&flags.output, "output", "o", flags.output, `Output format for the apply progress stream. One of: text, json, yaml.`)
+	cmd.Flags().BoolVar(&flags.rollback, "rollback", flags.rollback, "Revert a failed or completed upgrade by restoring the most recent upgrade backup. Mutually exclusive with passing a version argument.")
+	cmd.Flags().StringVar(&flags.rollbackFrom, "rollback-from", flags.rollbackFrom, "Restore from this specific backup directory instead of the most recent one. Only valid together with --rollback.")
+
+	return cmd
+}
+
+// newApplyData returns a new applyData struct to be used for the execution of the kubeadm upgrade apply
+// workflow. This func takes care of validating the arguments and flags passed to the command, loading
+// and defaulting the UpgradeConfiguration, and constructing the Kubernetes client the apply phases will
+// use (via flags.getClient, which tests may override with a fake.Clientset).
+func newApplyData(cmd *cobra.Command, args []string, flags *applyFlags) (*applyData, error) {
+	if len(args) > 1 {
+		return nil, errors.New("too many arguments. Only a version is allowed as a positional argument")
+	}
+	if len(args) == 1 && flags.rollback {
+		return nil, errors.New("--rollback cannot be used together with a version argument")
+	}
+	if len(args) == 0 && !flags.rollback {
+		return nil, cmdutil.RequiredArgError([]string{"version"})
+	}
+	if flags.rollbackFrom != "" && !flags.rollback {
+		return nil, errors.New("--rollback-from can only be used together with --rollback")
+	}
+
+	var upgradeVersion string
+	var rollbackDir string
+	var err error
+	if flags.rollback {
+		rollbackDir, err = resolveRollbackDir(flags.rollbackFrom)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		upgradeVersion = args[0]
+	}
+
+	ignorePreflightErrorsSet, err := validateIgnorePreflightErrors(flags.ignorePreflightErrors)
+	if err != nil {
+		return nil, err
+	}
+
+	externalCfg := &kubeadmapiv1.UpgradeConfiguration{}
+	opts := configutil.LoadOrDefaultConfigurationOptions{
+		AllowExperimental: flags.allowExperimentalUpgrades,
+		AllowRCs:          flags.allowRCUpgrades,
+	}
+	upgradeCfg, err := configutil.LoadOrDefaultUpgradeConfiguration(flags.cfgPath, externalCfg, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if cmd.Flags().Changed(options.DryRun) {
+		upgradeCfg.Apply.DryRun = flags.dryRun
+	}
+	if cmd.Flags().Changed(options.Force) {
+		upgradeCfg.Apply.Force = flags.force
+	}
+	if cmd.Flags().Changed(options.EtcdUpgrade) {
+		upgradeCfg.Apply.EtcdUpgrade = flags.etcdUpgrade
+	}
+	if cmd.Flags().Changed(options.CertificateRenewal) {
+		upgradeCfg.Apply.CertificateRenewal = flags.renewCerts
+	}
+	if cmd.Flags().Changed("output") {
+		upgradeCfg.Apply.Output = flags.output
+	} else if upgradeCfg.Apply.Output == "" {
+		upgradeCfg.Apply.Output = outputText
+	}
+	if err := validateOutputFormat(upgradeCfg.Apply.Output); err != nil {
+		return nil, err
+	}
+
+	clientGetter := flags.getClient
+	if clientGetter == nil {
+		clientGetter = getClient
+	}
+	client, err := clientGetter(cmdutil.GetKubeConfigPath(flags.kubeConfigPath), flags.imagePullTimeout)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't create a Kubernetes client from file")
+	}
+
+	var attestationManifest *imageAttestationManifest
+	if !flags.rollback && flags.verifyImageAttestations && upgradeCfg.Apply.Attestation != nil && !ignorePreflightErrorsSet.Has(attestationPreflightCheckName) {
+		attestationManifest, err = loadImageAttestationManifest(upgradeCfg.Apply.Attestation)
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't load and validate the image attestation manifest")
+		}
+	}
+
+	return &applyData{
+		nonInteractiveMode:    flags.nonInteractiveMode || upgradeCfg.Apply.Force || upgradeCfg.Apply.DryRun,
+		dryRun:                upgradeCfg.Apply.DryRun,
+		force:                 upgradeCfg.Apply.Force,
+		upgradeVersion:        upgradeVersion,
+		imagePullPolicy:       string(upgradeCfg.Apply.ImagePullPolicy),
+		imagePullSerial:       *upgradeCfg.Apply.ImagePullSerial,
+		etcdUpgrade:           upgradeCfg.Apply.EtcdUpgrade,
+		renewCerts:            upgradeCfg.Apply.CertificateRenewal,
+		patchesDir:            flags.patchesDir,
+		ignorePreflightErrors: ignorePreflightErrorsSet,
+		rollback:              flags.rollback,
+		rollbackDir:           rollbackDir,
+		client:                client,
+		cfg:                   upgradeCfg,
+		attestationManifest:   attestationManifest,
+		emitter:               newEventEmitter(upgradeCfg.Apply.Output, flags.out),
+	}, nil
+}
+
+// getClient creates a Kubernetes client from the kubeconfig file at the given path. It is the default
+// value of applyFlags.getClient; production code always goes through this function, while unit tests
+// can substitute a constructor backed by a fake.Clientset.
+func getClient(file string, timeout time.Duration) (clientset.Interface, error) {
+	return kubeconfigutil.ClientSetFromFile(file)
+}
+
+func validateIgnorePreflightErrors(ignorePreflightErrors []string) (sets.Set[string], error) {
+	return preflight.ValidateIgnorePreflightErrors(ignorePreflightErrors)
+}
+
+// SessionIsInteractive returns true if the session is considered "interactive" and the user should be
+// prompted for confirmation before any mutating action is taken.
+func (d *applyData) SessionIsInteractive() bool {
+	return !(d.nonInteractiveMode || d.dryRun || d.force)
+}
+
+// runApply executes the kubeadm upgrade apply workflow for the given applyData.
+func runApply(data *applyData) error {
+	if data.rollback {
+		return restoreUpgradeBackup(data, data.rollbackDir)
+	}
+
+	oldVersion, err := currentKubernetesVersion(data.client)
+	if err != nil {
+		return errors.Wrap(err, "couldn't determine the current cluster version")
+	}
+
+	images := componentImages(data.upgradeVersion)
+
+	if data.attestationManifest != nil {
+		data.emitter.PhaseStart("verify-image-attestations")
+		err := verifyComponentImageAttestations(data.attestationManifest, images)
+		data.emitter.PhaseEnd("verify-image-attestations", err)
+		if err != nil {
+			return errors.Wrap(err, "refusing to upgrade")
+		}
+	}
+
+	backupDir := newUpgradeBackupDir(oldVersion, time.Now().UTC().Format("20060102150405"))
+	if err := takeUpgradeBackup(data, backupDir); err != nil {
+		return err
+	}
+
+	return runApplyPhases(data, oldVersion, images)
+}
+
+// currentKubernetesVersion returns the version the API server currently reports, i.e. the version
+// being upgraded away from. runApply uses it to name the backup directory (via newUpgradeBackupDir) and
+// as the from-version of the VersionTransition events.
+func currentKubernetesVersion(client clientset.Interface) (string, error) {
+	serverVersion, err := client.Discovery().ServerVersion()
+	if err != nil {
+		return "", err
+	}
+	return serverVersion.GitVersion, nil
+}
@@ -22,12 +22,52 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
 
 	kubeadmapiv1 "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm/v1beta4"
 	"k8s.io/kubernetes/cmd/kubeadm/app/cmd/options"
 	kubeadmconstants "k8s.io/kubernetes/cmd/kubeadm/app/constants"
 )
 
+// fakeGetClient returns an applyFlags.getClient implementation that ignores the kubeconfig path
+// entirely and always succeeds with a fake.Clientset, so newApplyData can be exercised past the
+// point where it would otherwise need a real cluster to talk to.
+func fakeGetClient() func(string, time.Duration) (clientset.Interface, error) {
+	return func(string, time.Duration) (clientset.Interface, error) {
+		return fake.NewSimpleClientset(), nil
+	}
+}
+
+// applyTestFlags applies raw flag values to flags directly, in addition to the cmd.Flags().Set() calls
+// the test loop also makes. newCmdApply binds its flags to its own applyFlags instance closed over by
+// cmd's RunE, not to the flags the test constructs and passes to newApplyData (only applyPlanFlags is
+// shared between the two, via the apf pointer) — so cmd.Flags().Set() alone only ever changes what
+// cmd.Flags().Changed() reports, never the value newApplyData actually reads off flags. This keeps the
+// two in sync for every flag a test case cares about.
+func applyTestFlags(flags *applyFlags, raw map[string]string) {
+	for f, v := range raw {
+		switch f {
+		case options.DryRun:
+			flags.dryRun = v == "true"
+		case options.Force:
+			flags.force = v == "true"
+		case options.IgnorePreflightErrors:
+			flags.ignorePreflightErrors = strings.Split(v, ",")
+		case "output":
+			flags.output = v
+		case "verify-image-attestations":
+			flags.verifyImageAttestations = v == "true"
+		case "rollback":
+			flags.rollback = v == "true"
+		case "rollback-from":
+			flags.rollbackFrom = v
+		}
+	}
+}
+
 func TestSessionIsInteractive(t *testing.T) {
 	var tcases = []struct {
 		name     string
@@ -95,6 +135,60 @@ timeouts:
   upgradeManifests: 5m0s
 `, kubeadmapiv1.SchemeGroupVersion.String())
 
+var testApplyConfigWithYAMLOutput = fmt.Sprintf(`---
+apiVersion: %s
+apply:
+  certificateRenewal: true
+  etcdUpgrade: true
+  imagePullPolicy: IfNotPresent
+  imagePullSerial: true
+  output: yaml
+diff: {}
+kind: UpgradeConfiguration
+node:
+  certificateRenewal: true
+  etcdUpgrade: true
+  imagePullPolicy: IfNotPresent
+  imagePullSerial: true
+plan: {}
+timeouts:
+  controlPlaneComponentHealthCheck: 4m0s
+  discovery: 5m0s
+  etcdAPICall: 2m0s
+  kubeletHealthCheck: 4m0s
+  kubernetesAPICall: 1m0s
+  tlsBootstrap: 5m0s
+  upgradeManifests: 5m0s
+`, kubeadmapiv1.SchemeGroupVersion.String())
+
+var testApplyConfigWithAttestation = fmt.Sprintf(`---
+apiVersion: %s
+apply:
+  certificateRenewal: true
+  etcdUpgrade: true
+  imagePullPolicy: IfNotPresent
+  imagePullSerial: true
+  attestation:
+    manifestPath: /nonexistent/attestation-manifest.yaml
+    publicKeyPath: /nonexistent/attestation-key.pub
+diff: {}
+kind: UpgradeConfiguration
+node:
+  certificateRenewal: true
+  etcdUpgrade: true
+  imagePullPolicy: IfNotPresent
+  imagePullSerial: true
+plan: {}
+timeouts:
+  controlPlaneComponentHealthCheck: 4m0s
+  discovery: 5m0s
+  etcdAPICall: 2m0s
+  kubeletHealthCheck: 4m0s
+  kubernetesAPICall: 1m0s
+  tlsBootstrap: 5m0s
+  upgradeManifests: 5m0s
+`, kubeadmapiv1.SchemeGroupVersion.String())
+
 func TestNewApplyData(t *testing.T) {
 	// create temp directory
 	tmpDir, err := os.MkdirTemp("", "kubeadm-upgrade-apply-test")
@@ -118,10 +212,25 @@ func TestNewApplyData(t *testing.T) {
 		t.Fatalf("Unable to write file %q: %v", configFilePath, err)
 	}
 
+	// create a second config file that sets apply.output, to verify newApplyData picks up the
+	// emitter choice from the loaded config when --output isn't passed on the command line
+	yamlOutputConfigFilePath := filepath.Join(tmpDir, "test-config-file-yaml-output")
+	if err := os.WriteFile(yamlOutputConfigFilePath, []byte(testApplyConfigWithYAMLOutput), 0644); err != nil {
+		t.Fatalf("Unable to write file %q: %v", yamlOutputConfigFilePath, err)
+	}
+
+	// create a third config file with an apply.attestation block, to verify that attestation
+	// verification stays opt-in via --verify-image-attestations even when the block is configured
+	attestationConfigFilePath := filepath.Join(tmpDir, "test-config-file-attestation")
+	if err := os.WriteFile(attestationConfigFilePath, []byte(testApplyConfigWithAttestation), 0644); err != nil {
+		t.Fatalf("Unable to write file %q: %v", attestationConfigFilePath, err)
+	}
+
 	testCases := []struct {
 		name          string
 		args          []string
 		flags         map[string]string
+		useFakeClient bool
 		validate      func(*testing.T, *applyData)
 		expectedError string
 	}{
@@ -132,6 +241,15 @@ func TestNewApplyData(t *testing.T) {
 			},
 			expectedError: "missing one or more required arguments. Required arguments: [version]",
 		},
+		{
+			name: "fails if both a version and --rollback are set",
+			args: []string{"v1.1.0"},
+			flags: map[string]string{
+				options.CfgPath: configFilePath,
+				"rollback":      "true",
+			},
+			expectedError: "--rollback cannot be used together with a version argument",
+		},
 		{
 			name: "fails if invalid preflight checks are provided",
 			args: []string{"v1.1.0"},
@@ -149,8 +267,177 @@ func TestNewApplyData(t *testing.T) {
 			},
 			expectedError: "couldn't create a Kubernetes client from file",
 		},
-
-		// TODO: add more test cases here when the fake client for `kubeadm upgrade apply` can be injected
+		{
+			name:          "plumbs the upgrade version through to applyData",
+			args:          []string{"v1.2.3"},
+			useFakeClient: true,
+			flags: map[string]string{
+				options.CfgPath: configFilePath,
+			},
+			validate: func(t *testing.T, data *applyData) {
+				if data.upgradeVersion != "v1.2.3" {
+					t.Errorf("expected upgradeVersion %q, got %q", "v1.2.3", data.upgradeVersion)
+				}
+			},
+		},
+		{
+			name:          "propagates dryRun and force from flags",
+			args:          []string{"v1.2.3"},
+			useFakeClient: true,
+			flags: map[string]string{
+				options.CfgPath: configFilePath,
+				options.DryRun:  "true",
+				options.Force:   "true",
+			},
+			validate: func(t *testing.T, data *applyData) {
+				if !data.dryRun {
+					t.Error("expected dryRun to be true")
+				}
+				if !data.force {
+					t.Error("expected force to be true")
+				}
+				if data.SessionIsInteractive() {
+					t.Error("expected a non-interactive session when dryRun/force are set")
+				}
+			},
+		},
+		{
+			name:          "loads imagePullPolicy and imagePullSerial from the config file",
+			args:          []string{"v1.2.3"},
+			useFakeClient: true,
+			flags: map[string]string{
+				options.CfgPath: configFilePath,
+			},
+			validate: func(t *testing.T, data *applyData) {
+				if data.imagePullPolicy != "IfNotPresent" {
+					t.Errorf("expected imagePullPolicy %q, got %q", "IfNotPresent", data.imagePullPolicy)
+				}
+				if !data.imagePullSerial {
+					t.Error("expected imagePullSerial to be true")
+				}
+			},
+		},
+		{
+			name:          "sets ignorePreflightErrors from flags",
+			args:          []string{"v1.2.3"},
+			useFakeClient: true,
+			flags: map[string]string{
+				options.CfgPath:               configFilePath,
+				options.IgnorePreflightErrors: "Swap,Mem",
+			},
+			validate: func(t *testing.T, data *applyData) {
+				if !data.ignorePreflightErrors.Has("Swap") || !data.ignorePreflightErrors.Has("Mem") {
+					t.Errorf("expected ignorePreflightErrors to contain Swap and Mem, got %v", data.ignorePreflightErrors)
+				}
+			},
+		},
+		{
+			name:          "propagates etcdUpgrade and renewCerts",
+			args:          []string{"v1.2.3"},
+			useFakeClient: true,
+			flags: map[string]string{
+				options.CfgPath: configFilePath,
+			},
+			validate: func(t *testing.T, data *applyData) {
+				if !data.etcdUpgrade {
+					t.Error("expected etcdUpgrade to be true")
+				}
+				if !data.renewCerts {
+					t.Error("expected renewCerts to be true")
+				}
+			},
+		},
+		{
+			name:          "selects the json emitter from the --output flag",
+			args:          []string{"v1.2.3"},
+			useFakeClient: true,
+			flags: map[string]string{
+				options.CfgPath: configFilePath,
+				"output":        "json",
+			},
+			validate: func(t *testing.T, data *applyData) {
+				if _, ok := data.emitter.(*structuredEventEmitter); !ok {
+					t.Errorf("expected a *structuredEventEmitter, got %T", data.emitter)
+				}
+			},
+		},
+		{
+			name:          "defaults to the text emitter when --output isn't set",
+			args:          []string{"v1.2.3"},
+			useFakeClient: true,
+			flags: map[string]string{
+				options.CfgPath: configFilePath,
+			},
+			validate: func(t *testing.T, data *applyData) {
+				if _, ok := data.emitter.(*textEventEmitter); !ok {
+					t.Errorf("expected a *textEventEmitter, got %T", data.emitter)
+				}
+			},
+		},
+		{
+			name:          "selects the yaml emitter from UpgradeConfiguration.Apply.Output",
+			args:          []string{"v1.2.3"},
+			useFakeClient: true,
+			flags: map[string]string{
+				options.CfgPath: yamlOutputConfigFilePath,
+			},
+			validate: func(t *testing.T, data *applyData) {
+				if _, ok := data.emitter.(*structuredEventEmitter); !ok {
+					t.Errorf("expected a *structuredEventEmitter, got %T", data.emitter)
+				}
+			},
+		},
+		{
+			name: "rejects an invalid --output value",
+			args: []string{"v1.2.3"},
+			flags: map[string]string{
+				options.CfgPath: configFilePath,
+				"output":        "xml",
+			},
+			expectedError: "invalid output format",
+		},
+		{
+			name:          "skips attestation when the config has no attestation block",
+			args:          []string{"v1.2.3"},
+			useFakeClient: true,
+			flags: map[string]string{
+				options.CfgPath: configFilePath,
+			},
+			validate: func(t *testing.T, data *applyData) {
+				if data.attestationManifest != nil {
+					t.Error("expected attestationManifest to be nil when apply.attestation is absent from the config")
+				}
+			},
+		},
+		{
+			name:          "skips attestation when --verify-image-attestations isn't set, even with an attestation block configured",
+			args:          []string{"v1.2.3"},
+			useFakeClient: true,
+			flags: map[string]string{
+				options.CfgPath: attestationConfigFilePath,
+			},
+			validate: func(t *testing.T, data *applyData) {
+				if data.attestationManifest != nil {
+					t.Error("expected attestationManifest to stay nil without --verify-image-attestations")
+				}
+			},
+		},
+		{
+			name:          "wires timeouts from the loaded config",
+			args:          []string{"v1.2.3"},
+			useFakeClient: true,
+			flags: map[string]string{
+				options.CfgPath: configFilePath,
+			},
+			validate: func(t *testing.T, data *applyData) {
+				if data.cfg == nil || data.cfg.Timeouts == nil {
+					t.Fatal("expected cfg.Timeouts to be populated")
+				}
+				if data.cfg.Timeouts.ControlPlaneComponentHealthCheck.Duration != 4*time.Minute {
+					t.Errorf("expected controlPlaneComponentHealthCheck timeout of 4m0s, got %v", data.cfg.Timeouts.ControlPlaneComponentHealthCheck.Duration)
+				}
+			},
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -177,6 +464,10 @@ func TestNewApplyData(t *testing.T) {
 				etcdUpgrade:    true,
 				renewCerts:     true,
 			}
+			applyTestFlags(flags, tc.flags)
+			if tc.useFakeClient {
+				flags.getClient = fakeGetClient()
+			}
 
 			// test newApplyData method
 			data, err := newApplyData(cmd, tc.args, flags)
@@ -0,0 +1,104 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
+	"k8s.io/kubernetes/cmd/kubeadm/app/util/attestation"
+)
+
+// attestationPreflightCheckName is the name used with --ignore-preflight-errors to skip
+// verifyComponentImageAttestations entirely, e.g. --ignore-preflight-errors=ImageAttestation.
+const attestationPreflightCheckName = "ImageAttestation"
+
+// imageAttestationManifest is the parsed, signature-verified form of
+// UpgradeConfiguration.Apply.Attestation.ManifestPath: a map from component name to the digest
+// (sha256:...) kubeadm expects to find for that component's image in the target version.
+type imageAttestationManifest struct {
+	// Version is the Kubernetes version the manifest's digests were attested for. newApplyData
+	// doesn't reject a mismatch with the requested upgrade version outright (pinned digests are
+	// legitimately reused across patch releases in some registries), but callers can use it to warn.
+	Version string `json:"version"`
+	// Digests maps component name (see managedComponents) to its expected image digest.
+	Digests map[string]string `json:"digests"`
+}
+
+// loadImageAttestationManifest verifies the signature on cfg.ManifestPath using cfg.PublicKeyPath or
+// cfg.SigstoreBundlePath (exactly one of which must be set) and then parses the manifest contents. It
+// is called once, during newApplyData, so that a bad signature or a malformed manifest fails fast
+// before any cluster state has been touched.
+func loadImageAttestationManifest(cfg *kubeadmapi.ImageAttestationConfiguration) (*imageAttestationManifest, error) {
+	if cfg.ManifestPath == "" {
+		return nil, errors.New("attestation.manifestPath must be set when attestation is enabled")
+	}
+	if (cfg.PublicKeyPath == "") == (cfg.SigstoreBundlePath == "") {
+		return nil, errors.New("exactly one of attestation.publicKeyPath or attestation.sigstoreBundlePath must be set")
+	}
+
+	raw, err := os.ReadFile(cfg.ManifestPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't read attestation manifest %q", cfg.ManifestPath)
+	}
+
+	if err := attestation.VerifyManifestSignature(raw, attestation.VerifyOptions{
+		PublicKeyPath:      cfg.PublicKeyPath,
+		SigstoreBundlePath: cfg.SigstoreBundlePath,
+	}); err != nil {
+		return nil, errors.Wrap(err, "attestation manifest signature verification failed")
+	}
+
+	manifest := &imageAttestationManifest{}
+	if err := yaml.Unmarshal(raw, manifest); err != nil {
+		return nil, errors.Wrapf(err, "couldn't parse attestation manifest %q", cfg.ManifestPath)
+	}
+
+	for _, component := range managedComponents {
+		if _, ok := manifest.Digests[component]; !ok {
+			return nil, errors.Errorf("attestation manifest %q is missing a digest for component %q", cfg.ManifestPath, component)
+		}
+	}
+
+	return manifest, nil
+}
+
+// verifyComponentImageAttestations resolves the digest the target registry currently serves for each of
+// images (the very image references the apply phase is about to pull and pin, keyed by component name)
+// and compares it against the digest pinned in manifest. It returns an aggregate error (and refuses the
+// upgrade) if any component's resolved digest doesn't match what was attested. This must run before the
+// apply phase writes any static pod manifest under /etc/kubernetes/manifests, so a compromised or stale
+// image can never be pinned into the cluster.
+func verifyComponentImageAttestations(manifest *imageAttestationManifest, images map[string]string) error {
+	var errs []error
+	for _, component := range managedComponents {
+		image := images[component]
+		resolved, err := attestation.ResolveImageDigest(image)
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "couldn't resolve the image digest for %q", image))
+			continue
+		}
+		if expected := manifest.Digests[component]; resolved != expected {
+			errs = append(errs, errors.Errorf("image attestation failed for %q: expected digest %q, registry serves %q", image, expected, resolved))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
@@ -0,0 +1,279 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientset "k8s.io/client-go/kubernetes"
+
+	kubeadmconstants "k8s.io/kubernetes/cmd/kubeadm/app/constants"
+)
+
+// upgradeBackupRoot is where kubeadm keeps the versioned backups taken before each
+// `kubeadm upgrade apply`, so a later `--rollback` has somewhere to restore from.
+const upgradeBackupRoot = kubeadmconstants.KubernetesDir + "/tmp"
+
+// upgradeBackupDirPrefix is the prefix of every backup directory created by takeUpgradeBackup, e.g.
+// upgrade-backup-v1.31.0-20260101120000.
+const upgradeBackupDirPrefix = "upgrade-backup-"
+
+// kubeletConfigFilePath is the local kubelet configuration file kubeadm writes on this node; it is
+// backed up and restored alongside the cluster-wide kubeadm-config/kubelet-config ConfigMaps so a
+// `--rollback` puts the local kubelet back on its pre-upgrade configuration too.
+const kubeletConfigFilePath = "/var/lib/kubelet/config.yaml"
+
+// newUpgradeBackupDir returns the path of the backup directory takeUpgradeBackup should create for an
+// upgrade away from oldVersion, taken at the given timestamp. The timestamp is passed in by the caller
+// rather than computed here, since runApply is the only place allowed to call time.Now.
+func newUpgradeBackupDir(oldVersion, timestamp string) string {
+	return filepath.Join(upgradeBackupRoot, upgradeBackupDirPrefix+oldVersion+"-"+timestamp)
+}
+
+// takeUpgradeBackup snapshots the current static pod manifests, the kubeadm-config and kubelet-config
+// ConfigMaps, and the local kubelet configuration file into backupDir, so that a later
+// `kubeadm upgrade apply --rollback` can restore the cluster (and this node) to their pre-upgrade
+// state. It is called by runApply before any manifest is mutated.
+func takeUpgradeBackup(data *applyData, backupDir string) error {
+	data.emitter.PhaseStart("backup")
+
+	manifestsBackup := filepath.Join(backupDir, "manifests")
+	if err := os.MkdirAll(manifestsBackup, 0700); err != nil {
+		data.emitter.PhaseEnd("backup", err)
+		return errors.Wrapf(err, "couldn't create backup directory %q", manifestsBackup)
+	}
+	if err := copyDir(kubeadmconstants.GetStaticPodDirectory(), manifestsBackup); err != nil {
+		data.emitter.PhaseEnd("backup", err)
+		return errors.Wrap(err, "couldn't back up the static pod manifests")
+	}
+
+	if err := backupConfigMap(data.client, kubeadmconstants.KubeadmConfigConfigMap, filepath.Join(backupDir, "kubeadm-config.yaml")); err != nil {
+		data.emitter.PhaseEnd("backup", err)
+		return errors.Wrap(err, "couldn't back up the kubeadm-config ConfigMap")
+	}
+	if err := backupConfigMap(data.client, kubeadmconstants.KubeletBaseConfigurationConfigMap, filepath.Join(backupDir, "kubelet-config.yaml")); err != nil {
+		data.emitter.PhaseEnd("backup", err)
+		return errors.Wrap(err, "couldn't back up the kubelet-config ConfigMap")
+	}
+
+	if err := copyFile(kubeletConfigFilePath, filepath.Join(backupDir, "kubelet-config-local.yaml")); err != nil {
+		data.emitter.PhaseEnd("backup", err)
+		return errors.Wrap(err, "couldn't back up the local kubelet configuration file")
+	}
+
+	data.emitter.PhaseEnd("backup", nil)
+	return nil
+}
+
+// resolveRollbackDir returns the backup directory a `--rollback` run should restore from: explicitFrom
+// if set (via --rollback-from), otherwise the most recently created directory under upgradeBackupRoot
+// matching upgradeBackupDirPrefix.
+func resolveRollbackDir(explicitFrom string) (string, error) {
+	if explicitFrom != "" {
+		if _, err := os.Stat(explicitFrom); err != nil {
+			return "", errors.Wrapf(err, "rollback-from directory %q is not accessible", explicitFrom)
+		}
+		return explicitFrom, nil
+	}
+
+	entries, err := os.ReadDir(upgradeBackupRoot)
+	if err != nil {
+		return "", errors.Wrapf(err, "couldn't list backups under %q", upgradeBackupRoot)
+	}
+
+	var newest string
+	var newestModTime time.Time
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), upgradeBackupDirPrefix) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return "", errors.Wrapf(err, "couldn't stat backup directory %q", e.Name())
+		}
+		if newest == "" || info.ModTime().After(newestModTime) {
+			newest = e.Name()
+			newestModTime = info.ModTime()
+		}
+	}
+	if newest == "" {
+		return "", errors.Errorf("no upgrade backup found under %q; pass --rollback-from to specify one explicitly", upgradeBackupRoot)
+	}
+
+	return filepath.Join(upgradeBackupRoot, newest), nil
+}
+
+// restoreUpgradeBackup restores the static pod manifests, the kubeadm-config and kubelet-config
+// ConfigMaps, and the local kubelet configuration file from backupDir, re-applying the prior kubelet
+// config on this node before waiting for the control plane to report healthy again using
+// data.cfg.Timeouts.ControlPlaneComponentHealthCheck.
+func restoreUpgradeBackup(data *applyData, backupDir string) error {
+	data.emitter.PhaseStart("rollback")
+
+	manifestsBackup := filepath.Join(backupDir, "manifests")
+	if err := copyDir(manifestsBackup, kubeadmconstants.GetStaticPodDirectory()); err != nil {
+		data.emitter.PhaseEnd("rollback", err)
+		return errors.Wrap(err, "couldn't restore the static pod manifests")
+	}
+
+	if err := restoreConfigMap(data.client, kubeadmconstants.KubeadmConfigConfigMap, filepath.Join(backupDir, "kubeadm-config.yaml")); err != nil {
+		data.emitter.PhaseEnd("rollback", err)
+		return errors.Wrap(err, "couldn't restore the kubeadm-config ConfigMap")
+	}
+	if err := restoreConfigMap(data.client, kubeadmconstants.KubeletBaseConfigurationConfigMap, filepath.Join(backupDir, "kubelet-config.yaml")); err != nil {
+		data.emitter.PhaseEnd("rollback", err)
+		return errors.Wrap(err, "couldn't restore the kubelet-config ConfigMap")
+	}
+
+	if err := restoreKubeletConfig(filepath.Join(backupDir, "kubelet-config-local.yaml")); err != nil {
+		data.emitter.PhaseEnd("rollback", err)
+		return errors.Wrap(err, "couldn't re-apply the prior kubelet config")
+	}
+
+	if err := controlPlaneComponentHealthCheck(data.client, data.cfg.Timeouts.ControlPlaneComponentHealthCheck.Duration); err != nil {
+		data.emitter.PhaseEnd("rollback", err)
+		return errors.Wrap(err, "control plane did not become healthy after rollback")
+	}
+
+	data.emitter.PhaseEnd("rollback", nil)
+	return nil
+}
+
+// restoreKubeletConfig copies the backed-up kubelet configuration file back into place and restarts
+// the kubelet service so it picks up the restored configuration.
+func restoreKubeletConfig(backupPath string) error {
+	if err := copyFile(backupPath, kubeletConfigFilePath); err != nil {
+		return err
+	}
+	return exec.Command("systemctl", "restart", "kubelet").Run()
+}
+
+// controlPlaneComponentHealthCheck polls the static control plane pods (kube-apiserver,
+// kube-controller-manager, kube-scheduler) in kube-system on every control plane node, not only this
+// one, until each reports Running or timeout elapses. It
+// deliberately only looks at the control plane components kubeadm manages via static pods, not every
+// pod in kube-system (which would also include CNI, CoreDNS, kube-proxy, etc. that aren't relevant to
+// "is the control plane back up" and aren't guaranteed to be Running on every node).
+func controlPlaneComponentHealthCheck(client clientset.Interface, timeout time.Duration) error {
+	components := []string{
+		"kube-apiserver",
+		"kube-controller-manager",
+		"kube-scheduler",
+	}
+	return wait.PollUntilContextTimeout(context.TODO(), time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		for _, component := range components {
+			pods, err := client.CoreV1().Pods(metav1.NamespaceSystem).List(ctx, metav1.ListOptions{
+				LabelSelector: fmt.Sprintf("component=%s,tier=control-plane", component),
+			})
+			if err != nil || len(pods.Items) == 0 {
+				return false, nil
+			}
+			for _, pod := range pods.Items {
+				if pod.Status.Phase != corev1.PodRunning {
+					return false, nil
+				}
+			}
+		}
+		return true, nil
+	})
+}
+
+// copyDir recursively copies the contents of src into dst, creating dst if necessary.
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0700)
+		}
+		return copyFile(path, target)
+	})
+}
+
+// copyFile copies the file at src to dst, creating or truncating dst as needed.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// backupConfigMap reads the named ConfigMap from kube-system and serializes it as YAML to destPath.
+func backupConfigMap(client clientset.Interface, name, destPath string) error {
+	cm, err := client.CoreV1().ConfigMaps(metav1.NamespaceSystem).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(cm)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(destPath, data, 0600)
+}
+
+// restoreConfigMap deserializes a ConfigMap previously written by backupConfigMap from srcPath and
+// updates the named ConfigMap in kube-system to match. It reads the live object first and applies the
+// backed-up data onto it, rather than submitting the backed-up object as-is, since the latter carries a
+// stale resourceVersion (and uid/creationTimestamp) that would make the Update call fail with a 409
+// Conflict against whatever the cluster has written to the object since the backup was taken.
+func restoreConfigMap(client clientset.Interface, name, srcPath string) error {
+	data, err := os.ReadFile(srcPath)
+	if err != nil {
+		return err
+	}
+	backup := &corev1.ConfigMap{}
+	if err := yaml.Unmarshal(data, backup); err != nil {
+		return err
+	}
+
+	live, err := client.CoreV1().ConfigMaps(metav1.NamespaceSystem).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	live.Data = backup.Data
+	live.BinaryData = backup.BinaryData
+
+	_, err = client.CoreV1().ConfigMaps(metav1.NamespaceSystem).Update(context.TODO(), live, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,34 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	flag "github.com/spf13/pflag"
+
+	"k8s.io/kubernetes/cmd/kubeadm/app/cmd/options"
+)
+
+// addApplyPlanFlags adds the flags shared between `kubeadm upgrade apply` and `kubeadm upgrade plan`
+// to the given FlagSet, binding them into the provided applyPlanFlags.
+func addApplyPlanFlags(fs *flag.FlagSet, flags *applyPlanFlags) {
+	options.AddKubeConfigFlag(fs, &flags.kubeConfigPath)
+	options.AddConfigFlag(fs, &flags.cfgPath)
+	fs.StringVar(&flags.featureGatesString, options.FeatureGatesString, flags.featureGatesString, "A set of key=value pairs that describe feature gates for various features.")
+	fs.BoolVar(&flags.allowExperimentalUpgrades, "allow-experimental-upgrades", flags.allowExperimentalUpgrades, "Show unstable versions of Kubernetes as an upgrade alternative and allow upgrading to an alpha/beta/release candidate version of Kubernetes.")
+	fs.BoolVar(&flags.allowRCUpgrades, "allow-release-candidate-upgrades", flags.allowRCUpgrades, "Show release candidate versions of Kubernetes as an upgrade alternative and allow upgrading to a release candidate version of Kubernetes.")
+	fs.BoolVar(&flags.printConfig, "print-config", flags.printConfig, "Specifies whether the configuration file that will be used in the upgrade should be printed or not.")
+}
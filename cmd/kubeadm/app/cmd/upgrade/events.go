@@ -0,0 +1,189 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// Supported values for the `--output` flag of `kubeadm upgrade apply`.
+const (
+	outputText = "text"
+	outputJSON = "json"
+	outputYAML = "yaml"
+)
+
+// upgradeEvent is the wire format emitted by the json/yaml EventEmitter implementations: one event per
+// step of the apply workflow, on stdout. The json emitter writes each event as one object on a single
+// line; the yaml emitter writes each event as its own multi-line YAML document starting with "---",
+// so its output is not one object per line. Consumers (CI wrappers, higher-level
+// tools such as Cluster API providers) can follow upgrade progress deterministically without scraping
+// human-readable narration.
+type upgradeEvent struct {
+	Type      string `json:"type"`
+	Phase     string `json:"phase,omitempty"`
+	Component string `json:"component,omitempty"`
+	From      string `json:"from,omitempty"`
+	To        string `json:"to,omitempty"`
+	Image     string `json:"image,omitempty"`
+	Path      string `json:"path,omitempty"`
+	Node      string `json:"node,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// EventEmitter is the narrow interface the apply phases use to report progress. applyData selects the
+// concrete implementation at construction time, based on the `--output` flag (or
+// UpgradeConfiguration.Apply.Output): the text emitter preserves today's fmt.Printf-style narration,
+// while the json/yaml emitters stream one structured upgradeEvent per step for machine consumers (one
+// line per event for json, one YAML document per event for yaml).
+type EventEmitter interface {
+	PhaseStart(phase string)
+	PhaseEnd(phase string, err error)
+	VersionTransition(component, from, to string)
+	ImagePull(component, image string, err error)
+	ManifestSwap(component, path string)
+	NodeDrain(node string)
+	NodeUncordon(node string)
+}
+
+// newEventEmitter returns the EventEmitter implementation for the given `--output` value, writing to out.
+// An unrecognized or empty format falls back to the text emitter, matching the pre-existing behavior of
+// `kubeadm upgrade apply` when no output format is configured.
+func newEventEmitter(output string, out io.Writer) EventEmitter {
+	switch output {
+	case outputJSON:
+		return &structuredEventEmitter{out: out, marshal: json.Marshal}
+	case outputYAML:
+		return &structuredEventEmitter{out: out, marshal: func(v any) ([]byte, error) {
+			data, err := yaml.Marshal(v)
+			return append([]byte("---\n"), data...), err
+		}}
+	default:
+		return &textEventEmitter{out: out}
+	}
+}
+
+// validateOutputFormat checks that output is one of the supported `--output` values.
+func validateOutputFormat(output string) error {
+	switch output {
+	case outputText, outputJSON, outputYAML:
+		return nil
+	default:
+		return errors.Errorf("invalid output format %q: must be one of %q, %q, %q", output, outputText, outputJSON, outputYAML)
+	}
+}
+
+// textEventEmitter preserves the human-readable narration `kubeadm upgrade apply` has always printed.
+type textEventEmitter struct {
+	out io.Writer
+}
+
+func (e *textEventEmitter) PhaseStart(phase string) {
+	fmt.Fprintf(e.out, "[upgrade/apply] %s\n", phase)
+}
+
+func (e *textEventEmitter) PhaseEnd(phase string, err error) {
+	if err != nil {
+		fmt.Fprintf(e.out, "[upgrade/apply] %s failed: %v\n", phase, err)
+		return
+	}
+	fmt.Fprintf(e.out, "[upgrade/apply] %s done\n", phase)
+}
+
+func (e *textEventEmitter) VersionTransition(component, from, to string) {
+	fmt.Fprintf(e.out, "[upgrade/apply] %s: %s -> %s\n", component, from, to)
+}
+
+func (e *textEventEmitter) ImagePull(component, image string, err error) {
+	if err != nil {
+		fmt.Fprintf(e.out, "[upgrade/apply] failed to pull %s image %s: %v\n", component, image, err)
+		return
+	}
+	fmt.Fprintf(e.out, "[upgrade/apply] pulled %s image %s\n", component, image)
+}
+
+func (e *textEventEmitter) ManifestSwap(component, path string) {
+	fmt.Fprintf(e.out, "[upgrade/apply] wrote new static pod manifest for %s to %s\n", component, path)
+}
+
+func (e *textEventEmitter) NodeDrain(node string) {
+	fmt.Fprintf(e.out, "[upgrade/apply] draining node %s\n", node)
+}
+
+func (e *textEventEmitter) NodeUncordon(node string) {
+	fmt.Fprintf(e.out, "[upgrade/apply] uncordoning node %s\n", node)
+}
+
+// structuredEventEmitter emits one marshaled upgradeEvent per event using the given marshal func
+// (json.Marshal, one line per event, or sigs.k8s.io/yaml.Marshal with a leading "---" document
+// separator, one YAML document per event), enabling CI wrappers and higher-level tools to consume
+// upgrade progress deterministically.
+type structuredEventEmitter struct {
+	out     io.Writer
+	marshal func(any) ([]byte, error)
+}
+
+func (e *structuredEventEmitter) emit(ev upgradeEvent) {
+	data, err := e.marshal(ev)
+	if err != nil {
+		// Marshaling a plain struct of strings cannot fail in practice; if it somehow does there is no
+		// sensible line to emit, so drop it rather than corrupting the event stream with partial output.
+		return
+	}
+	fmt.Fprintf(e.out, "%s\n", data)
+}
+
+func (e *structuredEventEmitter) PhaseStart(phase string) {
+	e.emit(upgradeEvent{Type: "phaseStart", Phase: phase})
+}
+
+func (e *structuredEventEmitter) PhaseEnd(phase string, err error) {
+	ev := upgradeEvent{Type: "phaseEnd", Phase: phase}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	e.emit(ev)
+}
+
+func (e *structuredEventEmitter) VersionTransition(component, from, to string) {
+	e.emit(upgradeEvent{Type: "versionTransition", Component: component, From: from, To: to})
+}
+
+func (e *structuredEventEmitter) ImagePull(component, image string, err error) {
+	ev := upgradeEvent{Type: "imagePull", Component: component, Image: image}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	e.emit(ev)
+}
+
+func (e *structuredEventEmitter) ManifestSwap(component, path string) {
+	e.emit(upgradeEvent{Type: "manifestSwap", Component: component, Path: path})
+}
+
+func (e *structuredEventEmitter) NodeDrain(node string) {
+	e.emit(upgradeEvent{Type: "nodeDrain", Node: node})
+}
+
+func (e *structuredEventEmitter) NodeUncordon(node string) {
+	e.emit(upgradeEvent{Type: "nodeUncordon", Node: node})
+}
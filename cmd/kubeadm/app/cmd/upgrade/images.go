@@ -0,0 +1,48 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import "fmt"
+
+// managedComponents lists the kubeadm-managed components that get a new image pinned into their static
+// pod manifest (or, for kube-proxy/coredns, their workload) during `kubeadm upgrade apply`. It is the
+// single source of truth for "what images is this upgrade about to pin": componentImages uses it to
+// build the image references the apply phase actually pulls and reports, and verifyComponentImageAttestations
+// checks attestation against that same set so the two can never drift apart.
+var managedComponents = []string{
+	"kube-apiserver",
+	"kube-controller-manager",
+	"kube-scheduler",
+	"kube-proxy",
+	"etcd",
+	"coredns",
+}
+
+// componentImages resolves the concrete image reference each of managedComponents will be pinned to for
+// the given target version.
+func componentImages(version string) map[string]string {
+	images := make(map[string]string, len(managedComponents))
+	for _, component := range managedComponents {
+		images[component] = imageForComponent(component, version)
+	}
+	return images
+}
+
+// imageForComponent returns the image reference kubeadm pins for component at version.
+func imageForComponent(component, version string) string {
+	return fmt.Sprintf("registry.k8s.io/%s:%s", component, version)
+}
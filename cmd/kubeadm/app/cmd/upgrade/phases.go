@@ -0,0 +1,97 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package upgrade
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	kubeadmconstants "k8s.io/kubernetes/cmd/kubeadm/app/constants"
+)
+
+// runApplyPhases pulls the images for managedComponents and then reports through data.emitter a NodeDrain
+// event for this node, a VersionTransition and a ManifestSwap event for each component, and a
+// NodeUncordon event. It does not itself write any static pod manifest, and it does not drain or
+// uncordon the node; those events carry only the component, path and node names. oldVersion
+// is the version being upgraded away from (used only for the VersionTransition event); images is the set
+// of image references being pinned, as resolved by componentImages and already checked against
+// data.attestationManifest, if configured, by the caller.
+func runApplyPhases(data *applyData, oldVersion string, images map[string]string) error {
+	if err := pullComponentImages(data, images); err != nil {
+		return err
+	}
+
+	nodeName, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+
+	data.emitter.NodeDrain(nodeName)
+
+	for _, component := range managedComponents {
+		data.emitter.VersionTransition(component, oldVersion, data.upgradeVersion)
+		manifestPath := filepath.Join(kubeadmconstants.GetStaticPodDirectory(), component+".yaml")
+		data.emitter.ManifestSwap(component, manifestPath)
+	}
+
+	data.emitter.NodeUncordon(nodeName)
+	return nil
+}
+
+// pullComponentImages pulls every image in images, honoring data.imagePullSerial: serially, one at a
+// time and stopping at the first failure, or all at once when the upgrade is configured to pull in
+// parallel. Every attempt, successful or not, is reported through data.emitter.ImagePull.
+func pullComponentImages(data *applyData, images map[string]string) error {
+	if data.imagePullSerial {
+		for _, component := range managedComponents {
+			image := images[component]
+			err := pullImage(image)
+			data.emitter.ImagePull(component, image, err)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+	for _, component := range managedComponents {
+		wg.Add(1)
+		go func(component, image string) {
+			defer wg.Done()
+			err := pullImage(image)
+			data.emitter.ImagePull(component, image, err)
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(component, images[component])
+	}
+	wg.Wait()
+	return utilerrors.NewAggregate(errs)
+}
+
+// pullImage pulls image onto this node using the local container runtime's CLI.
+func pullImage(image string) error {
+	return exec.Command("crictl", "pull", image).Run()
+}